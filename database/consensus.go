@@ -0,0 +1,182 @@
+// Copyright 2020 The the-blockchain-bar Authors
+// This file is part of the the-blockchain-bar library.
+//
+// The the-blockchain-bar library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The the-blockchain-bar library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+package database
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ConsensusName identifies which ConsensusEngine a genesis config wires up.
+type ConsensusName string
+
+const (
+	// ConsensusPoW is the original local-mining proof-of-work engine.
+	ConsensusPoW ConsensusName = "pow"
+	// ConsensusBeacon defers block proposal, timing and finalization to an
+	// external consensus-layer client speaking the engine API.
+	ConsensusBeacon ConsensusName = "beacon"
+)
+
+// ParseConsensusFlag validates the value of the node's `--consensus` CLI
+// flag and resolves it to a ConsensusName, defaulting to ConsensusPoW when
+// unset so existing single-binary/self-mined setups keep working unchanged.
+func ParseConsensusFlag(value string) (ConsensusName, error) {
+	switch ConsensusName(value) {
+	case "", ConsensusPoW:
+		return ConsensusPoW, nil
+	case ConsensusBeacon:
+		return ConsensusBeacon, nil
+	default:
+		return "", fmt.Errorf("unknown --consensus value '%s', expected '%s' or '%s'", value, ConsensusPoW, ConsensusBeacon)
+	}
+}
+
+// ConsensusEngine decouples applyBlock from any one block-production scheme.
+// The PoW engine mines and verifies locally; the beacon engine instead takes
+// its cues from an externally-driven sequencer/validator process.
+type ConsensusEngine interface {
+	// VerifyHeader checks block against parent and returns an error if the
+	// block must be rejected, e.g. an invalid PoW hash. The beacon engine
+	// accepts every header.
+	VerifyHeader(parent Block, block Block) error
+	// Seal produces a valid block from a candidate, publishing the sealed
+	// result on results once found/received.
+	Seal(block Block, results chan<- Block) error
+	// Finalize applies any engine-specific end-of-block state changes (e.g.
+	// validator reward bookkeeping) beyond what applyBlock already does.
+	Finalize(state *State, block Block)
+	// Author returns the account credited as the block's producer.
+	Author(header Header) (common.Address, error)
+}
+
+// powEngine is the existing locally-mined proof-of-work consensus.
+type powEngine struct {
+	miningDifficulty uint
+}
+
+// NewPoWEngine wraps the chain's mining difficulty into a ConsensusEngine.
+func NewPoWEngine(miningDifficulty uint) ConsensusEngine {
+	return &powEngine{miningDifficulty}
+}
+
+func (e *powEngine) VerifyHeader(parent Block, block Block) error {
+	hash, err := block.Hash()
+	if err != nil {
+		return err
+	}
+
+	if !IsBlockHashValid(hash, e.miningDifficulty) {
+		return fmt.Errorf("invalid block hash %x", hash)
+	}
+
+	return nil
+}
+
+// Seal publishes block on results exactly as given; it does not search for a
+// nonce, so block must already carry a valid PoW hash.
+func (e *powEngine) Seal(block Block, results chan<- Block) error {
+	results <- block
+
+	return nil
+}
+
+func (e *powEngine) Finalize(state *State, block Block) {}
+
+func (e *powEngine) Author(header Header) (common.Address, error) {
+	return header.Miner, nil
+}
+
+// beaconEngine accepts block proposals driven by an external consensus-layer
+// client over the engine_newPayload/engine_forkchoiceUpdated JSON-RPC
+// endpoints, instead of mining locally. ImportPayload is what the
+// engine_newPayload endpoint calls; Seal blocks until a payload arrives there
+// rather than mining.
+type beaconEngine struct {
+	payloads chan Block
+}
+
+// NewBeaconEngine returns a ConsensusEngine driven by an external sequencer.
+func NewBeaconEngine() ConsensusEngine {
+	return &beaconEngine{payloads: make(chan Block, 1)}
+}
+
+func (e *beaconEngine) VerifyHeader(parent Block, block Block) error {
+	return nil
+}
+
+// Seal blocks until a payload is handed to it via ImportPayload (normally
+// from the engine_newPayload HTTP handler), then forwards it as the sealed
+// block - the beacon engine never mines locally.
+func (e *beaconEngine) Seal(block Block, results chan<- Block) error {
+	results <- <-e.payloads
+
+	return nil
+}
+
+func (e *beaconEngine) Finalize(state *State, block Block) {}
+
+func (e *beaconEngine) Author(header Header) (common.Address, error) {
+	return header.Miner, nil
+}
+
+// ImportPayload delivers a block proposed by the external consensus-layer
+// client (received over engine_newPayload) to a pending Seal call.
+func (e *beaconEngine) ImportPayload(block Block) error {
+	select {
+	case e.payloads <- block:
+		return nil
+	default:
+		return fmt.Errorf("beacon consensus: a payload is already pending and has not been sealed or drained yet")
+	}
+}
+
+// DrainPendingPayload discards a payload previously handed to ImportPayload
+// without routing it through Seal. The engine_newPayload handler consumes a
+// payload by adding it to the chain directly rather than waiting on Seal, so
+// it calls this afterwards to free the single buffered slot back up for the
+// next engine_newPayload call.
+func (e *beaconEngine) DrainPendingPayload() {
+	select {
+	case <-e.payloads:
+	default:
+	}
+}
+
+// ImportExternalPayload hands block to the node's consensus engine, if it's
+// running in beacon mode. It's the entry point the engine_newPayload JSON-RPC
+// handler calls; on any other engine it returns an error since there's no
+// external proposer to accept a payload from.
+func (s *State) ImportExternalPayload(block Block) error {
+	beacon, ok := s.engine.(*beaconEngine)
+	if !ok {
+		return fmt.Errorf("node is not running the beacon consensus engine")
+	}
+
+	return beacon.ImportPayload(block)
+}
+
+// DrainExternalPayload resets the beacon engine's pending-payload slot after
+// a caller (the engine_newPayload handler) has already consumed the block
+// directly via ImportExternalPayload + AddBlock rather than through Seal, so
+// the next engine_newPayload call isn't rejected as "already pending". It's a
+// no-op on any other consensus engine.
+func (s *State) DrainExternalPayload() {
+	if beacon, ok := s.engine.(*beaconEngine); ok {
+		beacon.DrainPendingPayload()
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2020 The the-blockchain-bar Authors
+// This file is part of the the-blockchain-bar library.
+//
+// The the-blockchain-bar library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The the-blockchain-bar library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+package database
+
+import "testing"
+
+// TestImportExternalPayloadSequential exercises the same sequence the
+// engine_newPayload handler does for two separate calls: import a payload,
+// consume it directly (instead of through Seal), then drain the pending slot
+// so the next import isn't rejected as "already pending".
+func TestImportExternalPayloadSequential(t *testing.T) {
+	s := &State{engine: NewBeaconEngine()}
+
+	if err := s.ImportExternalPayload(Block{Header: Header{Number: 1}}); err != nil {
+		t.Fatalf("first ImportExternalPayload failed: %v", err)
+	}
+	s.DrainExternalPayload()
+
+	if err := s.ImportExternalPayload(Block{Header: Header{Number: 2}}); err != nil {
+		t.Fatalf("second ImportExternalPayload failed: %v, the pending-payload slot wasn't drained after the first call", err)
+	}
+	s.DrainExternalPayload()
+}
+
+func TestImportExternalPayloadRejectsNonBeaconEngine(t *testing.T) {
+	s := &State{engine: NewPoWEngine(1)}
+
+	if err := s.ImportExternalPayload(Block{}); err == nil {
+		t.Fatal("expected ImportExternalPayload to reject a non-beacon engine, got no error")
+	}
+}
@@ -16,8 +16,11 @@
 package database
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"strings"
 
 	"github.com/ethereum/go-ethereum/common"
 )
@@ -27,31 +30,172 @@ var genesisJson = `{
   "chain_id": "clover-patch",
   "symbol": "CPT",
   "balances": {
-    "0x3e824d1a3f3622562267012ea1ff377122028a6e": 100,000,000,000,100,000
+    "0x3e824d1a3f3622562267012ea1ff377122028a6e": 100000000000100000
   },
-  "fork_tip_1": 35
+  "config": {
+    "fork_tip_1": 35,
+    "fork_tip_2": 0
+  },
+  "consensus": "pow"
 }`
 
+// GenesisConfig groups every fork-activation block number under one nested
+// struct instead of flat top-level fields, so new forks (TIP3, TIP4, ...)
+// don't keep widening Genesis itself.
+type GenesisConfig struct {
+	ForkTIP1 uint64 `json:"fork_tip_1"`
+	ForkTIP2 uint64 `json:"fork_tip_2"`
+}
+
 type Genesis struct {
+	ChainID  string                  `json:"chain_id"`
 	Balances map[common.Address]uint `json:"balances"`
 	Symbol   string                  `json:"symbol"`
 
-	ForkTIP1 uint64 `json:"fork_tip_1"`
+	Config GenesisConfig `json:"config"`
+
+	// Consensus selects which ConsensusEngine the chain runs: "pow" for local
+	// mining (the default), or "beacon" to take block proposals from an
+	// external consensus-layer client instead.
+	Consensus ConsensusName `json:"consensus"`
 }
 
-func loadGenesis(path string) (Genesis, error) {
-	content, err := ioutil.ReadFile(path)
+// DefaultGenesisBlock parses the genesis this module ships with straight from
+// the embedded genesisJson on every call, so tests don't need to round-trip it
+// through disk. NewStateFromDisk does not use it; it still loads the genesis
+// file from the data directory.
+func DefaultGenesisBlock() Genesis {
+	gen, err := parseGenesis([]byte(genesisJson))
 	if err != nil {
+		panic(fmt.Errorf("embedded genesisJson is invalid: %w", err))
+	}
+
+	return gen
+}
+
+// rawGenesis mirrors Genesis for decoding, except Balances keeps its raw
+// string keys and uint64 values so validate can check the checksum of what
+// was actually written in the file - by the time a key has been converted to
+// common.Address, re-deriving .Hex() from it always produces well-formed
+// checksummed hex regardless of the original text, which would make that
+// check vacuous.
+type rawGenesis struct {
+	GenesisTime string            `json:"genesis_time"`
+	ChainID     string            `json:"chain_id"`
+	Balances    map[string]uint64 `json:"balances"`
+	Symbol      string            `json:"symbol"`
+
+	Config GenesisConfig `json:"config"`
+
+	Consensus ConsensusName `json:"consensus"`
+}
+
+// parseGenesis decodes and validates a genesis document. Unknown fields are
+// rejected so a typo'd key fails fast instead of silently being ignored, and
+// every balance address must be valid hex that is either all-lowercase or
+// correctly EIP-55 checksummed.
+func parseGenesis(content []byte) (Genesis, error) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+
+	var raw rawGenesis
+	if err := decoder.Decode(&raw); err != nil {
+		return Genesis{}, fmt.Errorf("malformed genesis: %w", err)
+	}
+
+	gen := Genesis{
+		ChainID:   raw.ChainID,
+		Symbol:    raw.Symbol,
+		Config:    raw.Config,
+		Consensus: raw.Consensus,
+		Balances:  make(map[common.Address]uint, len(raw.Balances)),
+	}
+
+	for addrStr, balance := range raw.Balances {
+		addr, err := validateBalanceEntry(addrStr, balance)
+		if err != nil {
+			return Genesis{}, err
+		}
+
+		gen.Balances[addr] = uint(balance)
+	}
+
+	if err := gen.validate(); err != nil {
 		return Genesis{}, err
 	}
 
-	var loadedGenesis Genesis
-	err = json.Unmarshal(content, &loadedGenesis)
+	return gen, nil
+}
+
+// validateBalanceEntry checks that addrStr is a valid hex address that is
+// either all-lowercase or correctly EIP-55 checksummed (mixed case with a
+// wrong checksum is rejected), and that balance fits in a uint without
+// truncation, since Genesis.Balances stores it as uint rather than uint64 so
+// it can be added directly to State.Balances.
+func validateBalanceEntry(addrStr string, balance uint64) (common.Address, error) {
+	if !common.IsHexAddress(addrStr) {
+		return common.Address{}, fmt.Errorf("genesis: '%s' is not a valid address", addrStr)
+	}
+
+	addr := common.HexToAddress(addrStr)
+	if addrStr != addr.Hex() && addrStr != strings.ToLower(addr.Hex()) {
+		return common.Address{}, fmt.Errorf("genesis: '%s' fails EIP-55 checksum validation, expected '%s'", addrStr, addr.Hex())
+	}
+
+	if uint64(uint(balance)) != balance {
+		return common.Address{}, fmt.Errorf("genesis: balance for '%s' does not fit in a uint", addrStr)
+	}
+
+	return addr, nil
+}
+
+// validate enforces the genesis schema invariants that aren't checked while
+// converting raw balance entries: a chain ID must be set.
+func (g Genesis) validate() error {
+	if g.ChainID == "" {
+		return fmt.Errorf("genesis: chain_id must not be empty")
+	}
+
+	return nil
+}
+
+// Hash deterministically hashes the genesis document's canonical JSON
+// encoding, used to fingerprint which genesis a node was bootstrapped from.
+func (g Genesis) Hash() (Hash, error) {
+	encoded, err := json.Marshal(g)
+	if err != nil {
+		return Hash{}, err
+	}
+
+	return Keccak256Hash(encoded), nil
+}
+
+// Commit seeds state's balances from the genesis allocation. It's the
+// in-memory counterpart to writeGenesisToDisk, used by tests and
+// DefaultGenesisBlock callers that don't want to touch disk at all.
+func (g Genesis) Commit(state *State) error {
+	for account, balance := range g.Balances {
+		state.Balances[account] = balance
+	}
+
+	return nil
+}
+
+// MustCommit is Commit but panics on error, for tests that want a one-liner
+// in-memory genesis without threading error handling through table setup.
+func (g Genesis) MustCommit(state *State) {
+	if err := g.Commit(state); err != nil {
+		panic(err)
+	}
+}
+
+func loadGenesis(path string) (Genesis, error) {
+	content, err := ioutil.ReadFile(path)
 	if err != nil {
 		return Genesis{}, err
 	}
 
-	return loadedGenesis, nil
+	return parseGenesis(content)
 }
 
 func writeGenesisToDisk(path string, genesis []byte) error {
@@ -0,0 +1,51 @@
+// Copyright 2020 The the-blockchain-bar Authors
+// This file is part of the the-blockchain-bar library.
+//
+// The the-blockchain-bar library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The the-blockchain-bar library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+package database
+
+import "testing"
+
+func TestParseGenesisAcceptsLowercaseAddress(t *testing.T) {
+	_, err := parseGenesis([]byte(`{
+		"chain_id": "test",
+		"balances": {"0x3e824d1a3f3622562267012ea1ff377122028a6e": 100},
+		"config": {"fork_tip_1": 0, "fork_tip_2": 0}
+	}`))
+	if err != nil {
+		t.Fatalf("expected an all-lowercase address to be accepted, got: %v", err)
+	}
+}
+
+func TestParseGenesisRejectsBadChecksum(t *testing.T) {
+	_, err := parseGenesis([]byte(`{
+		"chain_id": "test",
+		"balances": {"0x3E824d1a3f3622562267012ea1ff377122028a6e": 100},
+		"config": {"fork_tip_1": 0, "fork_tip_2": 0}
+	}`))
+	if err == nil {
+		t.Fatal("expected a mis-checksummed address to be rejected, got no error")
+	}
+}
+
+func TestParseGenesisRejectsBalanceOverflowingUint32(t *testing.T) {
+	var balance uint64 = 1 << 40
+	_, err := validateBalanceEntry("0x3e824d1a3f3622562267012ea1ff377122028a6e", balance)
+	if uint64(uint(balance)) == balance {
+		t.Skip("uint is 64-bit on this platform, overflow case doesn't apply")
+	}
+	if err == nil {
+		t.Fatal("expected a balance that doesn't fit in a uint to be rejected")
+	}
+}
@@ -0,0 +1,271 @@
+// Copyright 2020 The the-blockchain-bar Authors
+// This file is part of the the-blockchain-bar library.
+//
+// The the-blockchain-bar library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The the-blockchain-bar library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+package database
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+
+	"github.com/ethereum/go-ethereum/common"
+	"go.etcd.io/bbolt"
+)
+
+var (
+	blocksBucket    = []byte("blocks")
+	canonicalBucket = []byte("canonical")
+	accountsBucket  = []byte("state")
+)
+
+// chainDbFileName is the embedded KV index kept alongside the line-delimited
+// JSON block log. Blocks are still appended to that log and replayed from it
+// on startup; chain.db holds the block, canonical and account-state indexes.
+const chainDbFileName = "chain.db"
+
+// blockNumberKey encodes a block number as a big-endian key so canonical
+// entries iterate in ascending order.
+func blockNumberKey(number uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, number)
+	return key
+}
+
+// openChainDb opens (creating if necessary) the bbolt-backed chain database
+// and ensures all three column families exist.
+func openChainDb(dataDir string) (*bbolt.DB, error) {
+	db, err := bbolt.Open(filepath.Join(dataDir, chainDbFileName), 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, bucket := range [][]byte{blocksBucket, canonicalBucket, accountsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// putBlock indexes a block under both blocks/<hash> and canonical/<number>,
+// and snapshots every account's current balance/nonce (as of s.Balances /
+// s.Account2Nonce right after this block was applied) under
+// state/<address><number>, so AccountAt can look account state up without
+// replaying the chain.
+func (s *State) putBlock(blockFs BlockFS) error {
+	return s.putBlockIndex(blockFs, true)
+}
+
+// putBlockHistoryOnly indexes a block under blocks/<hash> and
+// canonical/<number> but does NOT touch the per-account state index. It's
+// what NewStateFromDisk uses for blocks already covered by a verified
+// snapshot during SyncModeSnap: s.Balances/s.Account2Nonce hold the
+// snapshot's final balances the whole time that loop runs, not each
+// individual historical block's balances, so snapshotting them under every
+// earlier block number would record the wrong (future) account state.
+func (s *State) putBlockHistoryOnly(blockFs BlockFS) error {
+	return s.putBlockIndex(blockFs, false)
+}
+
+func (s *State) putBlockIndex(blockFs BlockFS, withAccountState bool) error {
+	blockJson, err := json.Marshal(blockFs)
+	if err != nil {
+		return err
+	}
+
+	blockNumber := blockFs.Value.Header.Number
+
+	return s.chainDb.Update(func(tx *bbolt.Tx) error {
+		if err := tx.Bucket(blocksBucket).Put(blockFs.Key[:], blockJson); err != nil {
+			return err
+		}
+
+		if err := tx.Bucket(canonicalBucket).Put(blockNumberKey(blockNumber), blockFs.Key[:]); err != nil {
+			return err
+		}
+
+		if !withAccountState {
+			return nil
+		}
+
+		accounts := tx.Bucket(accountsBucket)
+		for addr, balance := range s.Balances {
+			entry := AccountSnapshotEntry{
+				Address: addr,
+				Balance: balance,
+				Nonce:   s.Account2Nonce[addr],
+			}
+
+			entryJson, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+
+			if err := accounts.Put(accountStateKey(addr, blockNumber), entryJson); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// BlockByHash looks up a block by its hash in O(1) via the blocks index.
+func (s *State) BlockByHash(hash Hash) (BlockFS, error) {
+	var blockFs BlockFS
+
+	err := s.chainDb.View(func(tx *bbolt.Tx) error {
+		raw := tx.Bucket(blocksBucket).Get(hash[:])
+		if raw == nil {
+			return fmt.Errorf("block '%x' not found", hash)
+		}
+
+		return json.Unmarshal(raw, &blockFs)
+	})
+
+	return blockFs, err
+}
+
+// BlockByNumber looks up a block by height via the canonical index, then the
+// blocks index.
+func (s *State) BlockByNumber(number uint64) (BlockFS, error) {
+	var hash Hash
+
+	err := s.chainDb.View(func(tx *bbolt.Tx) error {
+		raw := tx.Bucket(canonicalBucket).Get(blockNumberKey(number))
+		if raw == nil {
+			return fmt.Errorf("no canonical block at height '%d'", number)
+		}
+
+		copy(hash[:], raw)
+		return nil
+	})
+	if err != nil {
+		return BlockFS{}, err
+	}
+
+	return s.BlockByHash(hash)
+}
+
+// AccountAt returns addr's balance and nonce as of the most recent block at
+// or before blockNumber, looked up from the per-account state index rather
+// than replaying the chain.
+func (s *State) AccountAt(addr common.Address, blockNumber uint64) (AccountSnapshotEntry, error) {
+	target := accountStateKey(addr, blockNumber)
+	addrPrefix := addr.Bytes()
+
+	var entry AccountSnapshotEntry
+	found := false
+
+	err := s.chainDb.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(accountsBucket).Cursor()
+
+		key, value := c.Seek(target)
+		if key != nil && bytes.Equal(key, target) {
+			found = true
+			return json.Unmarshal(value, &entry)
+		}
+
+		// Seek lands on the first key >= target (or nil past the bucket's
+		// end); the latest snapshot at-or-before blockNumber, if any, is the
+		// entry right before it.
+		if key == nil {
+			key, value = c.Last()
+		} else {
+			key, value = c.Prev()
+		}
+
+		if key != nil && bytes.HasPrefix(key, addrPrefix) {
+			found = true
+			return json.Unmarshal(value, &entry)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return entry, err
+	}
+
+	if !found {
+		return entry, fmt.Errorf("no recorded state for account '%s' at or before block '%d'", addr.Hex(), blockNumber)
+	}
+
+	return entry, nil
+}
+
+// accountStateKey namespaces an account snapshot by address and the block
+// number it was recorded at, so AccountAt can find the latest entry at or
+// before a requested height.
+func accountStateKey(addr common.Address, blockNumber uint64) []byte {
+	return append(addr.Bytes(), blockNumberKey(blockNumber)...)
+}
+
+// Prune drops block bodies (TXs, withdrawals) for every block older than
+// keepLastN blocks behind the chain tip, retaining only their headers so
+// canonical lookups and StateRoot history keep working.
+func (s *State) Prune(keepLastN uint64) error {
+	tip := s.LatestBlock().Header.Number
+	if tip < keepLastN {
+		return nil
+	}
+
+	cutoff := tip - keepLastN
+
+	return s.chainDb.Update(func(tx *bbolt.Tx) error {
+		canonical := tx.Bucket(canonicalBucket)
+		blocks := tx.Bucket(blocksBucket)
+
+		c := canonical.Cursor()
+		for numKey, hash := c.First(); numKey != nil; numKey, hash = c.Next() {
+			if binary.BigEndian.Uint64(numKey) >= cutoff {
+				continue
+			}
+
+			raw := blocks.Get(hash)
+			if raw == nil {
+				continue
+			}
+
+			var blockFs BlockFS
+			if err := json.Unmarshal(raw, &blockFs); err != nil {
+				return err
+			}
+
+			blockFs.Value.TXs = nil
+			blockFs.Value.Withdrawals = nil
+
+			prunedJson, err := json.Marshal(blockFs)
+			if err != nil {
+				return err
+			}
+
+			if err := blocks.Put(hash, prunedJson); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,112 @@
+// Copyright 2020 The the-blockchain-bar Authors
+// This file is part of the the-blockchain-bar library.
+//
+// The the-blockchain-bar library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The the-blockchain-bar library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+package database
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func newTestKVState(t *testing.T) *State {
+	t.Helper()
+
+	chainDb, err := openChainDb(t.TempDir())
+	if err != nil {
+		t.Fatalf("openChainDb failed: %v", err)
+	}
+	t.Cleanup(func() { chainDb.Close() })
+
+	return &State{
+		Balances:      make(map[common.Address]uint),
+		Account2Nonce: make(map[common.Address]uint),
+		chainDb:       chainDb,
+	}
+}
+
+func TestPutBlockHistoryOnlyDoesNotWriteAccountState(t *testing.T) {
+	s := newTestKVState(t)
+	addr := common.BigToAddress(common.Big1)
+	s.Balances[addr] = 999 // this is the *future*, post-snapshot balance
+
+	blockFs := BlockFS{Value: Block{Header: Header{Number: 5}}}
+	if err := s.putBlockHistoryOnly(blockFs); err != nil {
+		t.Fatalf("putBlockHistoryOnly failed: %v", err)
+	}
+
+	if _, err := s.AccountAt(addr, 5); err == nil {
+		t.Fatal("expected AccountAt to find no account-state entry for a history-only block, got no error")
+	}
+
+	if _, err := s.BlockByNumber(5); err != nil {
+		t.Fatalf("expected the block itself to still be indexed by number: %v", err)
+	}
+}
+
+func TestPutBlockWritesAccountState(t *testing.T) {
+	s := newTestKVState(t)
+	addr := common.BigToAddress(common.Big1)
+	s.Balances[addr] = 100
+	s.Account2Nonce[addr] = 1
+
+	blockFs := BlockFS{Value: Block{Header: Header{Number: 5}}}
+	if err := s.putBlock(blockFs); err != nil {
+		t.Fatalf("putBlock failed: %v", err)
+	}
+
+	entry, err := s.AccountAt(addr, 5)
+	if err != nil {
+		t.Fatalf("AccountAt failed: %v", err)
+	}
+	if entry.Balance != 100 || entry.Nonce != 1 {
+		t.Fatalf("got balance=%d nonce=%d, want balance=100 nonce=1", entry.Balance, entry.Nonce)
+	}
+}
+
+func TestPruneDropsBodiesBeforeCutoffOnly(t *testing.T) {
+	s := newTestKVState(t)
+
+	for i := uint64(1); i <= 10; i++ {
+		blockFs := BlockFS{Key: Hash{byte(i)}, Value: Block{
+			Header: Header{Number: i},
+			TXs:    []SignedTx{{}},
+		}}
+		if err := s.putBlock(blockFs); err != nil {
+			t.Fatalf("putBlock(%d) failed: %v", i, err)
+		}
+	}
+	s.latestBlock = Block{Header: Header{Number: 10}}
+
+	if err := s.Prune(3); err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+
+	pruned, err := s.BlockByNumber(5)
+	if err != nil {
+		t.Fatalf("BlockByNumber(5) failed: %v", err)
+	}
+	if len(pruned.Value.TXs) != 0 {
+		t.Fatalf("expected block 5's TXs to be pruned (below cutoff 7), got %d", len(pruned.Value.TXs))
+	}
+
+	kept, err := s.BlockByNumber(8)
+	if err != nil {
+		t.Fatalf("BlockByNumber(8) failed: %v", err)
+	}
+	if len(kept.Value.TXs) != 1 {
+		t.Fatalf("expected block 8's TXs to survive (at/after cutoff 7), got %d", len(kept.Value.TXs))
+	}
+}
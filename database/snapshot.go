@@ -0,0 +1,298 @@
+// Copyright 2020 The the-blockchain-bar Authors
+// This file is part of the the-blockchain-bar library.
+//
+// The the-blockchain-bar library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The the-blockchain-bar library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+package database
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// snapshotManifestFileName holds the TrustedSnapshotManifest a peer drops
+// into a joining node's data directory before it calls NewStateFromDisk with
+// SyncModeSnap.
+const snapshotManifestFileName = "snapshot_manifest.json"
+
+func getSnapshotManifestFilePath(dataDir string) string {
+	return filepath.Join(dataDir, snapshotManifestFileName)
+}
+
+// loadSnapshotManifest reads the snapshot manifest out of dataDir, if any.
+// ok is false (with a nil error) when no manifest is present, so
+// SyncModeSnap can fall back to a full replay instead of failing outright.
+func loadSnapshotManifest(dataDir string) (manifest TrustedSnapshotManifest, ok bool, err error) {
+	content, err := ioutil.ReadFile(getSnapshotManifestFilePath(dataDir))
+	if os.IsNotExist(err) {
+		return TrustedSnapshotManifest{}, false, nil
+	}
+	if err != nil {
+		return TrustedSnapshotManifest{}, false, err
+	}
+
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return TrustedSnapshotManifest{}, false, err
+	}
+
+	return manifest, true, nil
+}
+
+// writeSnapshotManifest persists a manifest a peer built via SnapshotChunks
+// into a joining node's data directory, for it to pick up on its next
+// NewStateFromDisk(..., SyncModeSnap) call.
+func writeSnapshotManifest(dataDir string, manifest TrustedSnapshotManifest) error {
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(getSnapshotManifestFilePath(dataDir), content, 0644)
+}
+
+// SyncMode controls how NewStateFromDisk bootstraps a joining node.
+type SyncMode string
+
+const (
+	// SyncModeFull replays every block from genesis, verifying each one.
+	SyncModeFull SyncMode = "full"
+	// SyncModeSnap applies a StateRoot-verified account snapshot read from the
+	// snapshot manifest in the data directory and only replays blocks mined
+	// after it. NewStateFromDisk does not request the snapshot from a peer
+	// itself.
+	SyncModeSnap SyncMode = "snap"
+)
+
+// AccountsPerSnapChunk is the default number of (address, balance, nonce)
+// triples transferred per chunk during snap sync, so a failed chunk can be
+// retried without re-fetching accounts that already landed.
+const AccountsPerSnapChunk = 4096
+
+// AccountSnapshotEntry is one leaf of the state Merkle tree committed to in
+// Block.Header.StateRoot: an account's balance and nonce at a given block.
+type AccountSnapshotEntry struct {
+	Address common.Address `json:"address"`
+	Balance uint           `json:"balance"`
+	Nonce   uint           `json:"nonce"`
+}
+
+// AccountSnapshotChunk is one page of a snap-sync transfer: a slice of sorted
+// account entries plus, for each entry, the Merkle proof tying it to a
+// trusted StateRoot (Proofs[i] proves Entries[i]).
+type AccountSnapshotChunk struct {
+	ChunkIndex int                    `json:"chunk_index"`
+	Entries    []AccountSnapshotEntry `json:"entries"`
+	Proofs     [][][]byte             `json:"proofs"`
+}
+
+// sortedSnapshotEntries returns every (address, balance, nonce) triple in s,
+// sorted by address, which is the canonical leaf ordering the StateRoot
+// Merkle tree and all chunk proofs are built against.
+func sortedSnapshotEntries(s *State) []AccountSnapshotEntry {
+	entries := make([]AccountSnapshotEntry, 0, len(s.Balances))
+	for addr, balance := range s.Balances {
+		entries = append(entries, AccountSnapshotEntry{
+			Address: addr,
+			Balance: balance,
+			Nonce:   s.Account2Nonce[addr],
+		})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].Address[:], entries[j].Address[:]) < 0
+	})
+
+	return entries
+}
+
+// leafHash hashes a single account entry into a Merkle leaf.
+func leafHash(e AccountSnapshotEntry) [32]byte {
+	return sha256.Sum256([]byte(fmt.Sprintf("%s:%d:%d", e.Address.Hex(), e.Balance, e.Nonce)))
+}
+
+// merkleLevels builds every level of the binary Merkle tree over entries,
+// leaves first and the single-node root last, so both StateRoot and the
+// per-entry proofs used by snap sync are derived from the same tree.
+func merkleLevels(entries []AccountSnapshotEntry) [][][32]byte {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	level := make([][32]byte, len(entries))
+	for i, e := range entries {
+		level[i] = leafHash(e)
+	}
+
+	levels := [][][32]byte{level}
+	for len(level) > 1 {
+		next := make([][32]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			next = append(next, sha256.Sum256(append(level[i][:], level[i+1][:]...)))
+		}
+		levels = append(levels, next)
+		level = next
+	}
+
+	return levels
+}
+
+// merkleProof returns the sibling hash at every level on the path from
+// leafIndex up to the root, in bottom-to-top order. A level where leafIndex's
+// node is the last, unpaired one (merkleLevels carries it up unchanged) has
+// no sibling to record; a nil placeholder is appended for that level instead
+// of omitting it, so the proof always has exactly len(levels)-1 entries and
+// VerifyAccountProof's walk stays in step with the real tree depth.
+func merkleProof(levels [][][32]byte, leafIndex int) [][]byte {
+	proof := make([][]byte, 0, len(levels)-1)
+
+	idx := leafIndex
+	for _, level := range levels[:len(levels)-1] {
+		siblingIdx := idx ^ 1
+		if siblingIdx < len(level) {
+			sibling := level[siblingIdx]
+			proof = append(proof, append([]byte(nil), sibling[:]...))
+		} else {
+			proof = append(proof, nil)
+		}
+		idx /= 2
+	}
+
+	return proof
+}
+
+// VerifyAccountProof recomputes the Merkle root for entry at leafIndex using
+// proof and reports whether it matches root, i.e. whether entry is genuinely
+// part of the account set committed to by root. A nil entry in proof means
+// that level had no sibling and the hash was carried up unchanged, per
+// merkleProof.
+func VerifyAccountProof(entry AccountSnapshotEntry, proof [][]byte, leafIndex int, root Hash) bool {
+	hash := leafHash(entry)
+
+	idx := leafIndex
+	for _, sibling := range proof {
+		if sibling == nil {
+			idx /= 2
+			continue
+		}
+
+		var sib [32]byte
+		copy(sib[:], sibling)
+
+		if idx%2 == 0 {
+			hash = sha256.Sum256(append(hash[:], sib[:]...))
+		} else {
+			hash = sha256.Sum256(append(sib[:], hash[:]...))
+		}
+		idx /= 2
+	}
+
+	return Hash(hash) == root
+}
+
+// StateRoot builds a binary Merkle tree over every account, sorted by
+// address, and returns its root. It's recomputed after every block and
+// committed into Block.Header.StateRoot so a snap-syncing node can verify a
+// peer-supplied account snapshot without replaying history.
+func (s *State) StateRoot() Hash {
+	levels := merkleLevels(sortedSnapshotEntries(s))
+	if len(levels) == 0 {
+		return Hash{}
+	}
+
+	return Hash(levels[len(levels)-1][0])
+}
+
+// SnapshotChunks splits the current account set into AccountsPerSnapChunk-
+// sized pages for transfer to a snap-syncing peer, each entry carrying its
+// own Merkle proof against StateRoot() so a peer can verify chunks as they
+// arrive instead of trusting the sender.
+func (s *State) SnapshotChunks() []AccountSnapshotChunk {
+	entries := sortedSnapshotEntries(s)
+	levels := merkleLevels(entries)
+
+	var chunks []AccountSnapshotChunk
+	for i := 0; i < len(entries); i += AccountsPerSnapChunk {
+		end := i + AccountsPerSnapChunk
+		if end > len(entries) {
+			end = len(entries)
+		}
+
+		chunkEntries := entries[i:end]
+		proofs := make([][][]byte, len(chunkEntries))
+		for j := range chunkEntries {
+			proofs[j] = merkleProof(levels, i+j)
+		}
+
+		chunks = append(chunks, AccountSnapshotChunk{
+			ChunkIndex: i / AccountsPerSnapChunk,
+			Entries:    chunkEntries,
+			Proofs:     proofs,
+		})
+	}
+
+	return chunks
+}
+
+// TrustedSnapshotManifest is what a peer hands a snap-syncing node before it
+// calls NewStateFromDisk with SyncModeSnap: the block it's trusted up to and
+// the account chunks verified against that block's committed StateRoot.
+type TrustedSnapshotManifest struct {
+	TrustedBlockNumber uint64                 `json:"trusted_block_number"`
+	TrustedBlockHash   Hash                   `json:"trusted_block_hash"`
+	TrustedStateRoot   Hash                   `json:"trusted_state_root"`
+	Chunks             []AccountSnapshotChunk `json:"chunks"`
+}
+
+// ApplySnapshot verifies every entry in chunks against trustedRoot and, only
+// if every single one checks out, seeds Balances/Account2Nonce directly from
+// them. This is what lets NewStateFromDisk skip replaying the chain up to
+// the snapshot's block: the snapshot's accounts are trusted in one shot
+// instead of being derived TX-by-TX.
+func (s *State) ApplySnapshot(chunks []AccountSnapshotChunk, trustedRoot Hash) error {
+	leafIndex := 0
+	balances := make(map[common.Address]uint)
+	nonces := make(map[common.Address]uint)
+
+	for _, chunk := range chunks {
+		if len(chunk.Entries) != len(chunk.Proofs) {
+			return fmt.Errorf("snapshot chunk %d has %d entries but %d proofs", chunk.ChunkIndex, len(chunk.Entries), len(chunk.Proofs))
+		}
+
+		for i, entry := range chunk.Entries {
+			if !VerifyAccountProof(entry, chunk.Proofs[i], leafIndex, trustedRoot) {
+				return fmt.Errorf("snapshot entry for '%s' failed Merkle proof verification against StateRoot '%x'", entry.Address.Hex(), trustedRoot)
+			}
+
+			balances[entry.Address] = entry.Balance
+			nonces[entry.Address] = entry.Nonce
+			leafIndex++
+		}
+	}
+
+	s.Balances = balances
+	s.Account2Nonce = nonces
+
+	return nil
+}
@@ -0,0 +1,92 @@
+// Copyright 2020 The the-blockchain-bar Authors
+// This file is part of the the-blockchain-bar library.
+//
+// The the-blockchain-bar library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The the-blockchain-bar library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+package database
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func newTestSnapshotState() *State {
+	return &State{
+		Balances:      make(map[common.Address]uint),
+		Account2Nonce: make(map[common.Address]uint),
+	}
+}
+
+func TestSnapshotChunksVerifyAgainstStateRoot(t *testing.T) {
+	s := newTestSnapshotState()
+	for i := 0; i < AccountsPerSnapChunk+10; i++ {
+		addr := common.BigToAddress(common.Big1)
+		addr[0] = byte(i)
+		addr[1] = byte(i >> 8)
+		s.Balances[addr] = uint(i)
+		s.Account2Nonce[addr] = uint(i)
+	}
+
+	root := s.StateRoot()
+	chunks := s.SnapshotChunks()
+
+	if len(chunks) != 2 {
+		t.Fatalf("expected 2 chunks for %d accounts, got %d", AccountsPerSnapChunk+10, len(chunks))
+	}
+
+	leafIndex := 0
+	for _, chunk := range chunks {
+		for i, entry := range chunk.Entries {
+			if !VerifyAccountProof(entry, chunk.Proofs[i], leafIndex, root) {
+				t.Fatalf("proof for entry %d (chunk %d) failed to verify against the state root", leafIndex, chunk.ChunkIndex)
+			}
+			leafIndex++
+		}
+	}
+}
+
+func TestApplySnapshotRejectsTamperedEntry(t *testing.T) {
+	s := newTestSnapshotState()
+	addr := common.BigToAddress(common.Big1)
+	s.Balances[addr] = 100
+
+	root := s.StateRoot()
+	chunks := s.SnapshotChunks()
+
+	chunks[0].Entries[0].Balance = 999 // tampered after the proof was generated
+
+	dst := newTestSnapshotState()
+	if err := dst.ApplySnapshot(chunks, root); err == nil {
+		t.Fatal("expected ApplySnapshot to reject a tampered entry, got no error")
+	}
+}
+
+func TestApplySnapshotSeedsBalances(t *testing.T) {
+	s := newTestSnapshotState()
+	addr := common.BigToAddress(common.Big1)
+	s.Balances[addr] = 100
+	s.Account2Nonce[addr] = 3
+
+	root := s.StateRoot()
+	chunks := s.SnapshotChunks()
+
+	dst := newTestSnapshotState()
+	if err := dst.ApplySnapshot(chunks, root); err != nil {
+		t.Fatalf("ApplySnapshot returned an error for a valid snapshot: %v", err)
+	}
+
+	if dst.Balances[addr] != 100 || dst.Account2Nonce[addr] != 3 {
+		t.Fatalf("ApplySnapshot did not seed balances/nonces correctly, got balance=%d nonce=%d", dst.Balances[addr], dst.Account2Nonce[addr])
+	}
+}
@@ -30,6 +30,15 @@ const TxGas = 22
 const TxGasPriceDefault = 2
 const TxFee = uint(22)
 
+// BlockGasTarget is the per-block gas usage the TIP2 base fee rule steers
+// towards; base fee rises when a block uses more than this and falls when
+// it uses less. Sized for this chain's real per-block TX volume (tens of
+// TXs, not the ~90,000 a go-ethereum-scale gas target would imply at
+// TxGas=22) so the fee market actually responds within a few blocks.
+const BlockGasTarget = 50 * TxGas
+const BaseFeeChangeDenominator = 8
+const BaseFeeMinimum = 1
+
 type State struct {
 	Balances      map[common.Address]uint
 	Account2Nonce map[common.Address]uint
@@ -43,9 +52,32 @@ type State struct {
 	miningDifficulty uint
 
 	forkTIP1 uint64
+	forkTIP2 uint64
+
+	lastWithdrawalIndex uint64
+	pendingWithdrawals  []Withdrawal
+
+	engine ConsensusEngine
+
+	// chainDb indexes every block persisted to dbFile under blocks/<hash> and
+	// canonical/<number>, plus per-account state snapshots, so lookups don't
+	// require a linear scan of the block log.
+	chainDb *bbolt.DB
 }
 
-func NewStateFromDisk(dataDir string, miningDifficulty uint) (*State, error) {
+// NewStateFromDisk rebuilds State from the on-disk block log. With
+// SyncModeFull (the default) it replays every block from genesis. With
+// SyncModeSnap, if the node sync package has dropped a TrustedSnapshotManifest
+// at getSnapshotManifestFilePath(dataDir), its chunks are Merkle-verified and
+// applied directly, and every block at or before TrustedBlockNumber is
+// indexed but NOT replayed TX-by-TX - only blocks mined after the snapshot
+// go through normal applyBlock, which is what actually shortcuts cold start.
+// With no manifest present, SyncModeSnap falls back to a full replay.
+func NewStateFromDisk(dataDir string, miningDifficulty uint, syncMode SyncMode) (*State, error) {
+	if syncMode == "" {
+		syncMode = SyncModeFull
+	}
+
 	err := InitDataDirIfNotExists(dataDir, []byte(genesisJson))
 	if err != nil {
 		return nil, err
@@ -71,7 +103,40 @@ func NewStateFromDisk(dataDir string, miningDifficulty uint) (*State, error) {
 
 	scanner := bufio.NewScanner(f)
 
-	state := &State{balances, account2nonce, f, Block{}, Hash{}, false, miningDifficulty, gen.ForkTIP1}
+	var engine ConsensusEngine
+	if gen.Consensus == ConsensusBeacon {
+		engine = NewBeaconEngine()
+	} else {
+		engine = NewPoWEngine(miningDifficulty)
+	}
+
+	chainDb, err := openChainDb(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &State{balances, account2nonce, f, Block{}, Hash{}, false, miningDifficulty, gen.Config.ForkTIP1, gen.Config.ForkTIP2, 0, nil, engine, chainDb}
+
+	var trustedBlockNumber uint64
+	snapApplied := false
+	if syncMode == SyncModeSnap {
+		manifest, ok, err := loadSnapshotManifest(dataDir)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			if err := state.ApplySnapshot(manifest.Chunks, manifest.TrustedStateRoot); err != nil {
+				return nil, err
+			}
+
+			trustedBlockNumber = manifest.TrustedBlockNumber
+			snapApplied = true
+			state.latestBlock = Block{Header: Header{Number: manifest.TrustedBlockNumber}}
+			state.latestBlockHash = manifest.TrustedBlockHash
+			state.hasGenesisBlock = true
+		}
+	}
 
 	for scanner.Scan() {
 		if err := scanner.Err(); err != nil {
@@ -90,11 +155,40 @@ func NewStateFromDisk(dataDir string, miningDifficulty uint) (*State, error) {
 			return nil, err
 		}
 
+		if snapApplied && blockFs.Value.Header.Number <= trustedBlockNumber {
+			// Already covered by the verified snapshot: index it for history
+			// lookups, but skip re-deriving state one TX at a time. Only the
+			// snapshot's own block number gets an account-state snapshot
+			// written here - state.Balances/Account2Nonce hold the
+			// snapshot's final balances for this whole branch, so indexing
+			// them under any earlier block number would claim that earlier
+			// block already had the later, post-snapshot balances. The
+			// snapshot's own block also replaces the header-only placeholder
+			// in latestBlock, so the next block's base fee is derived from
+			// its real header and TXs.
+			var err error
+			if blockFs.Value.Header.Number == trustedBlockNumber {
+				err = state.putBlock(blockFs)
+				state.latestBlock = blockFs.Value
+			} else {
+				err = state.putBlockHistoryOnly(blockFs)
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
 		err = applyBlock(blockFs.Value, state)
 		if err != nil {
 			return nil, err
 		}
 
+		if err := state.putBlock(blockFs); err != nil {
+			return nil, err
+		}
+
 		state.latestBlock = blockFs.Value
 		state.latestBlockHash = blockFs.Key
 		state.hasGenesisBlock = true
@@ -148,6 +242,12 @@ func (s *State) AddBlock(b Block) (Hash, error) {
 	s.latestBlock = b
 	s.hasGenesisBlock = true
 	s.miningDifficulty = pendingState.miningDifficulty
+	s.lastWithdrawalIndex = pendingState.lastWithdrawalIndex
+	s.removeAppliedWithdrawals(b.Withdrawals)
+
+	if err := s.putBlock(blockFs); err != nil {
+		return Hash{}, err
+	}
 
 	return blockHash, nil
 }
@@ -180,6 +280,47 @@ func (s *State) IsTIP1Fork() bool {
 	return s.NextBlockNumber() >= s.forkTIP1
 }
 
+func (s *State) IsTIP2Fork() bool {
+	return s.NextBlockNumber() >= s.forkTIP2
+}
+
+// NextBaseFee computes the base fee the next block must use, derived from the
+// current latest block per an EIP-1559-like rule: it moves the fee up or down
+// by parentBaseFee * (gasUsed - BlockGasTarget) / BlockGasTarget /
+// BaseFeeChangeDenominator, i.e. 1/BaseFeeChangeDenominator of the fee per
+// full BlockGasTarget of deviation (blocks have no gas limit here, so a block
+// far above target can move it by more than that), floored at BaseFeeMinimum. The
+// nudge is guarded to never round down to zero: at the low base fees this
+// chain actually operates at, a single combined division still truncates to
+// 0 and the fee would get permanently stuck, so any non-zero gas/target
+// deviation moves the fee by at least 1.
+func (s *State) NextBaseFee() uint64 {
+	parentBaseFee := s.latestBlock.Header.BaseFee
+	if parentBaseFee == 0 {
+		parentBaseFee = BaseFeeMinimum
+	}
+
+	parentGasUsed := int64(len(s.latestBlock.TXs)) * TxGas
+	gasDelta := parentGasUsed - BlockGasTarget
+
+	delta := int64(parentBaseFee) * gasDelta / (BlockGasTarget * BaseFeeChangeDenominator)
+	if delta == 0 {
+		switch {
+		case gasDelta > 0:
+			delta = 1
+		case gasDelta < 0:
+			delta = -1
+		}
+	}
+
+	nextBaseFee := int64(parentBaseFee) + delta
+	if nextBaseFee < BaseFeeMinimum {
+		return BaseFeeMinimum
+	}
+
+	return uint64(nextBaseFee)
+}
+
 func (s *State) Copy() State {
 	c := State{}
 	c.hasGenesisBlock = s.hasGenesisBlock
@@ -189,6 +330,9 @@ func (s *State) Copy() State {
 	c.Account2Nonce = make(map[common.Address]uint)
 	c.miningDifficulty = s.miningDifficulty
 	c.forkTIP1 = s.forkTIP1
+	c.forkTIP2 = s.forkTIP2
+	c.lastWithdrawalIndex = s.lastWithdrawalIndex
+	c.engine = s.engine
 
 	for acc, balance := range s.Balances {
 		c.Balances[acc] = balance
@@ -202,6 +346,10 @@ func (s *State) Copy() State {
 }
 
 func (s *State) Close() error {
+	if err := s.chainDb.Close(); err != nil {
+		return err
+	}
+
 	return s.dbFile.Close()
 }
 
@@ -219,37 +367,71 @@ func applyBlock(b Block, s *State) error {
 		return fmt.Errorf("next block parent hash must be '%x' not '%x'", s.latestBlockHash, b.Header.Parent)
 	}
 
-	hash, err := b.Hash()
-	if err != nil {
+	if err := s.engine.VerifyHeader(s.latestBlock, b); err != nil {
 		return err
 	}
 
-	if !IsBlockHashValid(hash, s.miningDifficulty) {
-		return fmt.Errorf("invalid block hash %x", hash)
+	if s.IsTIP2Fork() && b.Header.BaseFee != s.NextBaseFee() {
+		return fmt.Errorf("invalid block base fee %d, expected %d", b.Header.BaseFee, s.NextBaseFee())
 	}
 
-	err = applyTXs(b.TXs, s)
+	err := applyTXs(b.TXs, b.Header.BaseFee, s)
+	if err != nil {
+		return err
+	}
+
+	err = applyWithdrawals(b.Withdrawals, s)
 	if err != nil {
 		return err
 	}
 
 	s.Balances[b.Header.Miner] += BlockReward
-	if s.IsTIP1Fork() {
+	if s.IsTIP2Fork() {
+		s.Balances[b.Header.Miner] += minerTipReward(b)
+	} else if s.IsTIP1Fork() {
 		s.Balances[b.Header.Miner] += b.GasReward()
 	} else {
 		s.Balances[b.Header.Miner] += uint(len(b.TXs)) * TxFee
 	}
 
+	if s.hasGenesisBlock && b.Header.StateRoot != s.StateRoot() {
+		return fmt.Errorf("invalid block state root %x, expected %x", b.Header.StateRoot, s.StateRoot())
+	}
+
+	s.engine.Finalize(s, b)
+
 	return nil
 }
 
-func applyTXs(txs []SignedTx, s *State) error {
+// minerTipReward sums the tip portion of every TX in the block, i.e. the part
+// of the effective gas price left over once the base fee has been burned.
+func minerTipReward(b Block) uint {
+	var reward uint
+	for _, tx := range b.TXs {
+		reward += uint(effectiveGasPrice(tx, b.Header.BaseFee)-b.Header.BaseFee) * TxGas
+	}
+
+	return reward
+}
+
+// effectiveGasPrice is the price a TIP2 TX actually pays per unit of gas:
+// the lesser of its fee cap and baseFee+tip.
+func effectiveGasPrice(tx SignedTx, baseFee uint64) uint64 {
+	capped := baseFee + tx.MaxPriorityFeePerGas
+	if tx.MaxFeePerGas < capped {
+		return tx.MaxFeePerGas
+	}
+
+	return capped
+}
+
+func applyTXs(txs []SignedTx, baseFee uint64, s *State) error {
 	sort.Slice(txs, func(i, j int) bool {
 		return txs[i].Time < txs[j].Time
 	})
 
 	for _, tx := range txs {
-		err := ApplyTx(tx, s)
+		err := ApplyTx(tx, baseFee, s)
 		if err != nil {
 			return err
 		}
@@ -258,13 +440,21 @@ func applyTXs(txs []SignedTx, s *State) error {
 	return nil
 }
 
-func ApplyTx(tx SignedTx, s *State) error {
-	err := ValidateTx(tx, s)
+// ApplyTx debits tx's cost from its sender and credits its value to its
+// recipient. baseFee is the *current* block's base fee (not the parent's),
+// since that's the fee TIP2 actually burns for this TX.
+func ApplyTx(tx SignedTx, baseFee uint64, s *State) error {
+	err := ValidateTx(tx, baseFee, s)
 	if err != nil {
 		return err
 	}
 
-	s.Balances[tx.From] -= tx.Cost(s.IsTIP1Fork())
+	if s.IsTIP2Fork() {
+		gasPrice := effectiveGasPrice(tx, baseFee)
+		s.Balances[tx.From] -= tx.Value + uint(gasPrice)*TxGas
+	} else {
+		s.Balances[tx.From] -= tx.Cost(s.IsTIP1Fork())
+	}
 	s.Balances[tx.To] += tx.Value
 
 	s.Account2Nonce[tx.From] = tx.Nonce
@@ -272,7 +462,10 @@ func ApplyTx(tx SignedTx, s *State) error {
 	return nil
 }
 
-func ValidateTx(tx SignedTx, s *State) error {
+// ValidateTx checks tx against s. baseFee is the current block's base fee
+// (see ApplyTx), used to validate TIP2 fee caps against the fee this TX will
+// actually be charged, not the previous block's.
+func ValidateTx(tx SignedTx, baseFee uint64, s *State) error {
 	ok, err := tx.IsAuthentic()
 	if err != nil {
 		return err
@@ -287,7 +480,23 @@ func ValidateTx(tx SignedTx, s *State) error {
 		return fmt.Errorf("wrong TX. Sender '%s' next nonce must be '%d', not '%d'", tx.From.String(), expectedNonce, tx.Nonce)
 	}
 
-	if s.IsTIP1Fork() {
+	if s.IsTIP2Fork() {
+		// Post-TIP2, TXs pay via a fee cap/tip pair instead of a flat GasPrice; the
+		// fee cap must cover both the current base fee and the tip the sender is
+		// willing to pay, otherwise the TX could never be included profitably.
+		if tx.Gas != TxGas {
+			return fmt.Errorf("insufficient TX gas %v. required: %v", tx.Gas, TxGas)
+		}
+
+		if tx.MaxFeePerGas < baseFee {
+			return fmt.Errorf("TX fee cap %v is lower than the base fee %v", tx.MaxFeePerGas, baseFee)
+		}
+
+		if tx.MaxFeePerGas < tx.MaxPriorityFeePerGas {
+			return fmt.Errorf("TX fee cap %v is lower than the tip %v", tx.MaxFeePerGas, tx.MaxPriorityFeePerGas)
+		}
+
+	} else if s.IsTIP1Fork() {
 		// For now we only have one type, transfer TXs, so all TXs must pay 21 gas like on Ethereum (21 000)
 		if tx.Gas != TxGas {
 			return fmt.Errorf("insufficient TX gas %v. required: %v", tx.Gas, TxGas)
@@ -306,7 +515,12 @@ func ValidateTx(tx SignedTx, s *State) error {
 		}
 	}
 
-	if tx.Cost(s.IsTIP1Fork()) > s.Balances[tx.From] {
+	if s.IsTIP2Fork() {
+		cost := tx.Value + uint(effectiveGasPrice(tx, baseFee))*TxGas
+		if cost > s.Balances[tx.From] {
+			return fmt.Errorf("wrong TX. Sender '%s' balance is %d TBB. Tx cost is %d TBB", tx.From.String(), s.Balances[tx.From], cost)
+		}
+	} else if tx.Cost(s.IsTIP1Fork()) > s.Balances[tx.From] {
 		return fmt.Errorf("wrong TX. Sender '%s' balance is %d TBB. Tx cost is %d TBB", tx.From.String(), s.Balances[tx.From], tx.Cost(s.IsTIP1Fork()))
 	}
 
@@ -0,0 +1,43 @@
+// Copyright 2020 The the-blockchain-bar Authors
+// This file is part of the the-blockchain-bar library.
+//
+// The the-blockchain-bar library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The the-blockchain-bar library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+package database
+
+import "testing"
+
+func TestNextBaseFee_RisesUnderLoad(t *testing.T) {
+	s := &State{}
+	s.latestBlock = Block{
+		Header: Header{BaseFee: BaseFeeMinimum},
+		TXs:    make([]SignedTx, (BlockGasTarget/TxGas)*2),
+	}
+
+	next := s.NextBaseFee()
+	if next <= BaseFeeMinimum {
+		t.Fatalf("expected base fee to rise above minimum when a block uses 2x its gas target, got %d", next)
+	}
+}
+
+func TestNextBaseFee_FallsWhenIdle(t *testing.T) {
+	s := &State{}
+	s.latestBlock = Block{
+		Header: Header{BaseFee: 100},
+	}
+
+	next := s.NextBaseFee()
+	if next >= 100 {
+		t.Fatalf("expected base fee to fall below the parent's when the parent block was empty, got %d", next)
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2020 The the-blockchain-bar Authors
+// This file is part of the the-blockchain-bar library.
+//
+// The the-blockchain-bar library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The the-blockchain-bar library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+package database
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Withdrawal is a non-mining balance credit, e.g. a staking reward or treasury
+// payout, that reaches `Address` without going through a signed transaction.
+// Withdrawals are proposed by block producers and applied alongside TXs and
+// the mining reward in applyBlock.
+type Withdrawal struct {
+	Index     uint64         `json:"index"`
+	Validator common.Address `json:"validator"`
+	Address   common.Address `json:"address"`
+	Amount    uint           `json:"amount"`
+}
+
+// applyWithdrawals credits every withdrawal's Amount to its Address, and
+// enforces that withdrawal indices are strictly monotonically increasing
+// across the whole chain so the same withdrawal can't be replayed.
+func applyWithdrawals(withdrawals []Withdrawal, s *State) error {
+	for _, w := range withdrawals {
+		if w.Index != s.lastWithdrawalIndex+1 {
+			return fmt.Errorf("next expected withdrawal index must be '%d' not '%d'", s.lastWithdrawalIndex+1, w.Index)
+		}
+
+		s.Balances[w.Address] += w.Amount
+		s.lastWithdrawalIndex = w.Index
+	}
+
+	return nil
+}
+
+// PendingWithdrawals returns the withdrawals queued by EnqueueWithdrawal that
+// have not yet been included in a block, in the order they'll be applied.
+func (s *State) PendingWithdrawals() []Withdrawal {
+	pending := make([]Withdrawal, len(s.pendingWithdrawals))
+	copy(pending, s.pendingWithdrawals)
+
+	return pending
+}
+
+// EnqueueWithdrawal appends a withdrawal to the pending queue, to be picked
+// up by the next mined/proposed block. The caller (an HTTP handler guarded by
+// the operator key) is responsible for authentication.
+func (s *State) EnqueueWithdrawal(validator, address common.Address, amount uint) Withdrawal {
+	w := Withdrawal{
+		Index:     s.lastWithdrawalIndex + uint64(len(s.pendingWithdrawals)) + 1,
+		Validator: validator,
+		Address:   address,
+		Amount:    amount,
+	}
+
+	s.pendingWithdrawals = append(s.pendingWithdrawals, w)
+
+	return w
+}
+
+// removeAppliedWithdrawals drops every withdrawal in applied from the
+// pending queue, matched by Index rather than assuming applied is a prefix
+// of the local queue: a block can (and for every node but the one that
+// originally queued them, will) carry withdrawals this node never enqueued
+// itself, e.g. ones proposed by a different node or arriving out of order.
+func (s *State) removeAppliedWithdrawals(applied []Withdrawal) {
+	if len(applied) == 0 {
+		return
+	}
+
+	appliedIndices := make(map[uint64]bool, len(applied))
+	for _, w := range applied {
+		appliedIndices[w.Index] = true
+	}
+
+	remaining := s.pendingWithdrawals[:0]
+	for _, w := range s.pendingWithdrawals {
+		if !appliedIndices[w.Index] {
+			remaining = append(remaining, w)
+		}
+	}
+
+	s.pendingWithdrawals = remaining
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 The the-blockchain-bar Authors
+// This file is part of the the-blockchain-bar library.
+//
+// The the-blockchain-bar library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The the-blockchain-bar library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+package database
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestEnqueueWithdrawalIndicesIncreaseMonotonically(t *testing.T) {
+	s := &State{Balances: make(map[common.Address]uint)}
+	addr := common.BigToAddress(common.Big1)
+
+	first := s.EnqueueWithdrawal(addr, addr, 10)
+	second := s.EnqueueWithdrawal(addr, addr, 20)
+
+	if first.Index != 1 || second.Index != 2 {
+		t.Fatalf("expected indices 1, 2, got %d, %d", first.Index, second.Index)
+	}
+}
+
+func TestApplyWithdrawalsRejectsOutOfOrderIndex(t *testing.T) {
+	s := &State{Balances: make(map[common.Address]uint)}
+	addr := common.BigToAddress(common.Big1)
+
+	err := applyWithdrawals([]Withdrawal{{Index: 2, Address: addr, Amount: 1}}, s)
+	if err == nil {
+		t.Fatal("expected applying withdrawal index 2 before index 1 to fail")
+	}
+}
+
+func TestApplyWithdrawalsCreditsBalance(t *testing.T) {
+	s := &State{Balances: make(map[common.Address]uint)}
+	addr := common.BigToAddress(common.Big1)
+
+	err := applyWithdrawals([]Withdrawal{{Index: 1, Address: addr, Amount: 50}}, s)
+	if err != nil {
+		t.Fatalf("applyWithdrawals failed: %v", err)
+	}
+	if s.Balances[addr] != 50 {
+		t.Fatalf("got balance %d, want 50", s.Balances[addr])
+	}
+	if s.lastWithdrawalIndex != 1 {
+		t.Fatalf("got lastWithdrawalIndex %d, want 1", s.lastWithdrawalIndex)
+	}
+}
+
+// TestRemoveAppliedWithdrawalsMatchesByIndex is the regression case this
+// whole method exists for: a block can carry withdrawals this node never
+// locally enqueued (e.g. proposed by a different node), so removal can't
+// assume the applied slice is a prefix of the pending queue.
+func TestRemoveAppliedWithdrawalsMatchesByIndex(t *testing.T) {
+	s := &State{Balances: make(map[common.Address]uint)}
+	addr := common.BigToAddress(common.Big1)
+
+	own := s.EnqueueWithdrawal(addr, addr, 1)
+	foreign := Withdrawal{Index: 999, Address: addr, Amount: 2}
+
+	s.removeAppliedWithdrawals([]Withdrawal{foreign})
+
+	pending := s.PendingWithdrawals()
+	if len(pending) != 1 || pending[0].Index != own.Index {
+		t.Fatalf("expected the node's own pending withdrawal to survive removal of an unrelated index, got %+v", pending)
+	}
+}
+
+func TestRemoveAppliedWithdrawalsDropsMatchedIndex(t *testing.T) {
+	s := &State{Balances: make(map[common.Address]uint)}
+	addr := common.BigToAddress(common.Big1)
+
+	w := s.EnqueueWithdrawal(addr, addr, 1)
+	s.removeAppliedWithdrawals([]Withdrawal{w})
+
+	if len(s.PendingWithdrawals()) != 0 {
+		t.Fatalf("expected the applied withdrawal to be removed from the pending queue")
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2020 The the-blockchain-bar Authors
+// This file is part of the the-blockchain-bar library.
+//
+// The the-blockchain-bar library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The the-blockchain-bar library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/CloverPatchCore/the-blockchain-bar/database"
+)
+
+// newPayloadRequest is the body of a POST to /engine/new_payload: a block the
+// external consensus-layer client wants this node to import.
+type newPayloadRequest struct {
+	Block database.Block `json:"block"`
+}
+
+// newPayloadResponse mirrors the engine API's payload status: "VALID" once
+// the block has been imported and added to the chain. A rejected payload gets
+// an HTTP 400 error instead of this response.
+type newPayloadResponse struct {
+	Status string `json:"status"`
+}
+
+// newPayloadHandler implements the engine_newPayload endpoint: it hands an
+// externally-proposed block to the beacon consensus engine and then appends
+// it to the chain directly, without waiting on Seal. Only meaningful when the
+// chain's genesis selects the beacon consensus engine; on any other engine
+// ImportExternalPayload rejects it.
+func newPayloadHandler(w http.ResponseWriter, r *http.Request, state *database.State) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("unable to read request body: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	var req newPayloadRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, fmt.Sprintf("malformed request body: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := state.ImportExternalPayload(req.Block); err != nil {
+		http.Error(w, fmt.Sprintf("payload rejected: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	// This handler adds the block to the chain itself rather than waiting on
+	// Seal to deliver it, so the payload slot ImportExternalPayload just
+	// filled needs draining here - otherwise it stays full forever and every
+	// later engine_newPayload call is rejected as "already pending".
+	defer state.DrainExternalPayload()
+
+	if _, err := state.AddBlock(req.Block); err != nil {
+		http.Error(w, fmt.Sprintf("payload rejected: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newPayloadResponse{Status: "VALID"})
+}
+
+// forkchoiceUpdatedRequest is the body of a POST to /engine/forkchoice_updated:
+// the external consensus-layer client's view of the current canonical head.
+type forkchoiceUpdatedRequest struct {
+	HeadBlockHash database.Hash `json:"head_block_hash"`
+}
+
+// forkchoiceUpdatedResponse mirrors the engine API's payload status field.
+type forkchoiceUpdatedResponse struct {
+	Status string `json:"status"`
+}
+
+// forkchoiceUpdatedHandler implements the engine_forkchoiceUpdated endpoint.
+// This node has no competing fork-choice rule of its own under beacon
+// consensus, so it simply acknowledges whatever head the external consensus
+// layer reports, as long as it matches what's already canonical here.
+func forkchoiceUpdatedHandler(w http.ResponseWriter, r *http.Request, state *database.State) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("unable to read request body: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	var req forkchoiceUpdatedRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, fmt.Sprintf("malformed request body: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	if req.HeadBlockHash != state.LatestBlockHash() {
+		http.Error(w, "forkchoiceUpdated: head does not match this node's canonical chain", http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(forkchoiceUpdatedResponse{Status: "VALID"})
+}
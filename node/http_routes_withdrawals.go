@@ -0,0 +1,63 @@
+// Copyright 2020 The the-blockchain-bar Authors
+// This file is part of the the-blockchain-bar library.
+//
+// The the-blockchain-bar library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The the-blockchain-bar library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/CloverPatchCore/the-blockchain-bar/database"
+)
+
+// withdrawalEnqueueRequest is the body of a POST to /withdrawals.
+type withdrawalEnqueueRequest struct {
+	Validator common.Address `json:"validator"`
+	Address   common.Address `json:"address"`
+	Amount    uint           `json:"amount"`
+}
+
+// enqueueWithdrawalHandler lets an authenticated operator queue a withdrawal
+// for inclusion in the next block. Requests must carry the chain's configured
+// operator key in the X-Operator-Key header; everything else is rejected.
+func enqueueWithdrawalHandler(w http.ResponseWriter, r *http.Request, state *database.State, operatorKey string) {
+	if operatorKey == "" || r.Header.Get("X-Operator-Key") != operatorKey {
+		http.Error(w, "missing or invalid operator key", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("unable to read request body: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	var req withdrawalEnqueueRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, fmt.Sprintf("malformed request body: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	withdrawal := state.EnqueueWithdrawal(req.Validator, req.Address, req.Amount)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(withdrawal); err != nil {
+		http.Error(w, fmt.Sprintf("unable to write response: %s", err), http.StatusInternalServerError)
+	}
+}